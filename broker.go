@@ -0,0 +1,64 @@
+package mego
+
+// Broker 讓事件可以跨越多個執行中的行程互相傳遞，使叢集中部署在不同節點上的客戶端
+// 都能收到同一個事件，解決單一行程的 `Emit` 只能觸及本地連線的限制。
+type Broker interface {
+	// Publish 會將指定事件、頻道與酬載發布出去，讓叢集中的其他節點得以收到並轉發給本地客戶端。
+	Publish(event, channel string, payload []byte) error
+	// Subscribe 會註冊一個回呼函式，每當從叢集中收到任一節點（包含此節點自己）發布的訊息時都會被呼叫一次；
+	// 設置 Broker 後 `Emit` 只會發布而不直接寫入本地連線，本地客戶端同樣是經由此回呼收到事件。
+	Subscribe(handler func(event, channel string, payload []byte))
+}
+
+// brokerMessage 是事件透過 Broker 傳遞時所使用的封裝格式。
+type brokerMessage struct {
+	Event   string `json:"event"`
+	Channel string `json:"channel"`
+	Payload []byte `json:"payload"`
+}
+
+// UseBroker 會讓引擎改用指定的 Broker 進行跨節點的事件發佈與接收。設置後，
+// `Emit` 會先將事件發布至 Broker，並啟動背景監聽，將 Broker 轉發回來的訊息
+// 重新分派給本地連線中訂閱該事件頻道的客戶端。`EmitFilter`、`EmitMultiple`
+// 所指定的接收者（過濾函式、客戶端切片）無法序列化給其他節點，因此兩者
+// 不受此設置影響，永遠只會送達本地連線。
+func (e *Engine) UseBroker(b Broker) *Engine {
+	e.broker = b
+	b.Subscribe(e.dispatchBroker)
+	return e
+}
+
+// dispatchBroker 會將從 Broker 收到的事件重新轉發給本地連線中訂閱該事件頻道的客戶端。
+func (e *Engine) dispatchBroker(event, channel string, payload []byte) {
+	e.mu.RLock()
+	defer e.mu.RUnlock()
+
+	ev, ok := e.Events[event]
+	if !ok {
+		return
+	}
+
+	var result interface{}
+	if err := e.codec.Unmarshal(payload, &result); err != nil {
+		return
+	}
+
+	// 頻道為空字串時表示這是一次廣播給該事件底下所有頻道的訊息，
+	// 語意須與 `Emit` 一致，否則跨節點的廣播會因為找不到名稱為空字串的頻道而靜默遺失。
+	if channel == "" {
+		for _, ch := range ev.Channels {
+			for _, sess := range ch.Sessions {
+				sess.write(Response{Event: event, Result: result})
+			}
+		}
+		return
+	}
+
+	ch, ok := ev.Channels[channel]
+	if !ok {
+		return
+	}
+	for _, sess := range ch.Sessions {
+		sess.write(Response{Event: event, Result: result})
+	}
+}
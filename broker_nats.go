@@ -0,0 +1,39 @@
+package mego
+
+import (
+	"encoding/json"
+
+	"github.com/nats-io/nats.go"
+)
+
+// NatsBroker 是以 NATS 實作的 Broker，適合不想額外維運 Redis、且需要更低延遲的叢集部署。
+type NatsBroker struct {
+	conn    *nats.Conn
+	subject string
+}
+
+// NewNatsBroker 會以傳入的 NATS 連線建立一個 NatsBroker，
+// `subject` 是叢集節點之間共用來傳遞事件的 NATS 主題名稱。
+func NewNatsBroker(conn *nats.Conn, subject string) *NatsBroker {
+	return &NatsBroker{conn: conn, subject: subject}
+}
+
+// Publish 會將事件封裝成 JSON 後發布至 NATS 主題。
+func (b *NatsBroker) Publish(event, channel string, payload []byte) error {
+	data, err := json.Marshal(brokerMessage{Event: event, Channel: channel, Payload: payload})
+	if err != nil {
+		return err
+	}
+	return b.conn.Publish(b.subject, data)
+}
+
+// Subscribe 會訂閱 NATS 主題，每當收到任一節點（包含此節點自己）發布的訊息時就呼叫傳入的回呼函式。
+func (b *NatsBroker) Subscribe(handler func(event, channel string, payload []byte)) {
+	b.conn.Subscribe(b.subject, func(msg *nats.Msg) {
+		var m brokerMessage
+		if err := json.Unmarshal(msg.Data, &m); err != nil {
+			return
+		}
+		handler(m.Event, m.Channel, m.Payload)
+	})
+}
@@ -0,0 +1,46 @@
+package mego
+
+import (
+	"encoding/json"
+
+	"github.com/go-redis/redis"
+)
+
+// RedisBroker 是以 Redis 發布/訂閱（Pub/Sub）實作的 Broker，讓多個 Mego 節點能共用
+// 同一份 Redis 伺服器互相轉發事件，適合部署在有負載平衡器的多節點環境下。
+type RedisBroker struct {
+	client  *redis.Client
+	channel string
+}
+
+// NewRedisBroker 會以傳入的 Redis 連線選項建立一個 RedisBroker，
+// `channel` 是叢集節點之間共用來傳遞事件的 Redis 頻道名稱。
+func NewRedisBroker(opt *redis.Options, channel string) *RedisBroker {
+	return &RedisBroker{
+		client:  redis.NewClient(opt),
+		channel: channel,
+	}
+}
+
+// Publish 會將事件封裝成 JSON 後發布至 Redis 頻道。
+func (b *RedisBroker) Publish(event, channel string, payload []byte) error {
+	data, err := json.Marshal(brokerMessage{Event: event, Channel: channel, Payload: payload})
+	if err != nil {
+		return err
+	}
+	return b.client.Publish(b.channel, data).Err()
+}
+
+// Subscribe 會訂閱 Redis 頻道，每當收到任一節點（包含此節點自己）發布的訊息時就呼叫傳入的回呼函式。
+func (b *RedisBroker) Subscribe(handler func(event, channel string, payload []byte)) {
+	sub := b.client.Subscribe(b.channel)
+	go func() {
+		for msg := range sub.Channel() {
+			var m brokerMessage
+			if err := json.Unmarshal([]byte(msg.Payload), &m); err != nil {
+				continue
+			}
+			handler(m.Event, m.Channel, m.Payload)
+		}
+	}()
+}
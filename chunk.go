@@ -0,0 +1,297 @@
+package mego
+
+import (
+	"fmt"
+	"hash/crc32"
+	"net/http"
+	"time"
+
+	uuid "github.com/satori/go.uuid"
+)
+
+// defaultUploadTTL 是上傳階段在沒有任何動作之後，被視為已遺棄而可以被清除的時間長度。
+const defaultUploadTTL = 30 * time.Minute
+
+// FileMeta 呈現了一次上傳所攜帶的中繼資料。
+type FileMeta struct {
+	// Name 是客戶端所上傳檔案的原始檔名。
+	Name string `msgpack:"name" json:"name"`
+	// Size 是客戶端所上傳檔案的總位元組數。
+	Size int64 `msgpack:"size" json:"size"`
+}
+
+// ChunkProcessor 是區塊上傳的處理介面，開發者可以實作此介面來決定每個區塊最終該如何被儲存，
+// 未自訂時 Receive 會使用 `DefaultChunkProcessor` 將區塊依序寫入暫存檔案。
+type ChunkProcessor interface {
+	// Begin 會在客戶端開始（或帶著既有權杖恢復）一次上傳時呼叫一次。
+	Begin(c *Context, meta FileMeta) error
+	// Chunk 會在每次收到一個已通過驗證的區塊時呼叫。
+	Chunk(c *Context, seq int, data []byte) error
+	// End 會在客戶端表示所有區塊皆已送出時呼叫，用來完成此次上傳。
+	End(c *Context) error
+	// Abort 會在上傳被中止（客戶端主動取消、累計大小超過 `MaxFileSize`，或上傳逾時遺棄而被清除）時呼叫，
+	// 用來清除已寫入的暫存資料；單一區塊超過 `MaxChunkSize` 只會被拒絕，不會呼叫 Abort。
+	Abort(c *Context) error
+}
+
+// fileFrame 是客戶端上傳檔案時，透過已註冊方法所傳送的請求參數格式。
+type fileFrame struct {
+	// Stage 表示此訊框屬於上傳流程的哪個階段："begin"、"chunk"、"end" 或 "abort"。
+	Stage string `msgpack:"stage" json:"stage"`
+	// Token 是這次上傳的權杖，重新連線後可帶著同一個權杖呼叫 "begin" 以恢復進度。
+	Token string `msgpack:"token" json:"token"`
+	// Seq 是此區塊預期的序號，由伺服器依照目前進度核對，不相符時會要求重送。
+	Seq int `msgpack:"seq" json:"seq"`
+	// Checksum 是此區塊內容的檢查碼（例如 CRC32 的十六進位字串），為空則不驗證。
+	Checksum string `msgpack:"checksum" json:"checksum"`
+	// Data 是此區塊的原始位元組。
+	Data []byte `msgpack:"data" json:"data"`
+	// Meta 僅在 "begin" 階段夾帶，為此次上傳的中繼資料。
+	Meta FileMeta `msgpack:"meta" json:"meta"`
+}
+
+// uploadSession 記錄了一次進行中的上傳進度，讓客戶端重新連線後可以從中斷的地方接續上傳。
+type uploadSession struct {
+	Token string
+	// Owner 是開始這次上傳的客戶端識別碼（見 `Session.identity`），只有同一個客戶端能夠
+	// 接續、完成或中止此次上傳，避免其他階段得知或猜到權杖後就能竄改、完成甚至取得上傳結果；
+	// 使用 `identity` 而非階段自身的 `ID`，讓客戶端斷線重連後能以同一個權杖接續上傳進度。
+	Owner        string
+	Method       *Method
+	Processor    ChunkProcessor
+	Meta         FileMeta
+	Next         int
+	Received     int64
+	LastActivity time.Time
+}
+
+// expired 回報這次上傳距離上次動作是否已經超過 `defaultUploadTTL`，逾時即視為已遺棄。
+func (u *uploadSession) expired() bool {
+	return time.Since(u.LastActivity) > defaultUploadTTL
+}
+
+// checksum 會計算傳入位元組切片的 CRC32 檢查碼，以十六進位字串表示。
+func checksum(data []byte) string {
+	return fmt.Sprintf("%x", crc32.ChecksumIEEE(data))
+}
+
+// maxChunkSize 會依照方法選項、引擎選項的優先順序取得允許的最大區塊位元組數，`0` 表示不限制。
+func (e *Engine) maxChunkSize(m *Method) int {
+	if m.Option != nil && m.Option.MaxChunkSize > 0 {
+		return m.Option.MaxChunkSize
+	}
+	if e.Option != nil {
+		return e.Option.MaxChunkSize
+	}
+	return 0
+}
+
+// maxFileSize 會依照方法選項、引擎選項的優先順序取得允許的最大檔案位元組數，`0` 表示不限制。
+func (e *Engine) maxFileSize(m *Method) int {
+	if m.Option != nil && m.Option.MaxFileSize > 0 {
+		return m.Option.MaxFileSize
+	}
+	if e.Option != nil {
+		return e.Option.MaxFileSize
+	}
+	return 0
+}
+
+// handleChunk 會處理透過 Receive、ReceiveWith 註冊的方法所收到的上傳訊框，
+// 依序驗證區塊序號、檢查碼與大小限制，並在每個階段回應對應的檔案狀態碼。
+func (e *Engine) handleChunk(sess *Session, r *http.Request, req Request, method *Method) {
+	codec := sess.codec
+	if codec == nil {
+		codec = e.codec
+	}
+
+	var frame fileFrame
+	if err := decodeParams(codec, req.Params, &frame); err != nil {
+		sess.write(Response{ID: req.ID, Error: ResponseError{Code: StatusInvalid, Message: err.Error()}})
+		return
+	}
+
+	ctx := &Context{
+		Session: sess,
+		Method:  method,
+		ID:      req.ID,
+		Request: r,
+		data:    req.Params,
+		Keys:    map[string]interface{}{"token": frame.Token},
+	}
+
+	// 每個階段都可能呼叫使用者自訂的 `ChunkProcessor`，其恐慌不會經過一般方法鏈的
+	// `Recovery` 中介軟體，因此必須在這裡自行復原，避免單一上傳的錯誤拖垮整個引擎。
+	e.safeDispatch(sess, req, func(sess *Session, req Request) {
+		switch frame.Stage {
+		case "begin":
+			e.beginChunk(ctx, sess, req, frame, method)
+		case "chunk":
+			e.acceptChunk(ctx, sess, req, frame)
+		case "end":
+			e.endChunk(ctx, sess, req, frame)
+		case "abort":
+			e.abortChunk(ctx, sess, req, frame)
+		default:
+			sess.write(Response{ID: req.ID, Error: ResponseError{Code: StatusInvalid, Message: "unknown upload stage"}})
+		}
+	})
+}
+
+// beginChunk 會開始一次新的上傳，若客戶端帶著既有權杖呼叫則視為恢復先前的進度。
+func (e *Engine) beginChunk(ctx *Context, sess *Session, req Request, frame fileFrame, method *Method) {
+	token := frame.Token
+	if token == "" {
+		token = uuid.NewV4().String()
+	}
+	ctx.Keys["token"] = token
+
+	e.uploadsMu.Lock()
+	up, resuming := e.uploads[token]
+	var stale *uploadSession
+	if resuming && up.expired() {
+		stale = up
+		delete(e.uploads, token)
+		resuming = false
+	}
+	if resuming && up.Owner != sess.identity() {
+		e.uploadsMu.Unlock()
+		sess.write(Response{ID: req.ID, Error: ResponseError{Code: StatusNoPermission, Message: "upload token belongs to another client"}})
+		return
+	}
+	if !resuming {
+		up = &uploadSession{Token: token, Owner: sess.identity(), Method: method, Processor: method.Processor, Meta: frame.Meta, LastActivity: time.Now()}
+		e.uploads[token] = up
+	}
+	e.uploadsMu.Unlock()
+
+	// 舊的上傳已逾時遺棄，讓處理器清除其暫存資料後才開始這次全新的上傳。
+	if stale != nil {
+		stale.Processor.Abort(ctx)
+	}
+
+	// Begin 無論是全新上傳還是帶著既有權杖恢復都會呼叫，讓需要在恢復時重新驗證 `meta`
+	// 或重建內部狀態的自訂處理器也能遵循文件所述的行為；`DefaultChunkProcessor` 的
+	// Begin 本身即為冪等操作（已開啟的暫存檔案不會被重新建立），重複呼叫並無副作用。
+	if err := up.Processor.Begin(ctx, frame.Meta); err != nil {
+		if !resuming {
+			e.uploadsMu.Lock()
+			delete(e.uploads, token)
+			e.uploadsMu.Unlock()
+		}
+		sess.write(Response{ID: req.ID, Error: ResponseError{Code: StatusFileAbort, Message: err.Error()}})
+		return
+	}
+
+	up.LastActivity = time.Now()
+	sess.write(Response{ID: req.ID, Result: H{"token": token, "next": up.Next}, Error: ResponseError{Code: StatusFileNext}})
+}
+
+// acceptChunk 會驗證並寫入一個上傳區塊，核對序號與檢查碼、限制區塊與總檔案大小。
+func (e *Engine) acceptChunk(ctx *Context, sess *Session, req Request, frame fileFrame) {
+	e.uploadsMu.Lock()
+	up, ok := e.uploads[frame.Token]
+	if ok && (up.Owner != sess.identity() || up.expired()) {
+		ok = false
+	}
+	e.uploadsMu.Unlock()
+	if !ok {
+		sess.write(Response{ID: req.ID, Error: ResponseError{Code: StatusFileAbort, Message: "unknown upload token"}})
+		return
+	}
+
+	// 序號不相符或檢查碼錯誤都表示客戶端需要重新傳送目前進度所指的區塊，這必須先於大小限制核對，
+	// 否則客戶端因漏接確認而重送一個早已被接受的區塊時，會被當成新進度平白算進 `MaxFileSize`。
+	if frame.Seq != up.Next || (frame.Checksum != "" && checksum(frame.Data) != frame.Checksum) {
+		sess.write(Response{ID: req.ID, Result: H{"token": up.Token, "next": up.Next}, Error: ResponseError{Code: StatusFileRetry}})
+		return
+	}
+
+	if max := e.maxChunkSize(up.Method); max > 0 && len(frame.Data) > max {
+		sess.write(Response{ID: req.ID, Error: ResponseError{Code: StatusFileTooLarge}})
+		return
+	}
+	if max := e.maxFileSize(up.Method); max > 0 && up.Received+int64(len(frame.Data)) > int64(max) {
+		up.Processor.Abort(ctx)
+		e.uploadsMu.Lock()
+		delete(e.uploads, frame.Token)
+		e.uploadsMu.Unlock()
+		sess.write(Response{ID: req.ID, Error: ResponseError{Code: StatusFileTooLarge}})
+		return
+	}
+
+	if err := up.Processor.Chunk(ctx, frame.Seq, frame.Data); err != nil {
+		sess.write(Response{ID: req.ID, Result: H{"token": up.Token, "next": up.Next}, Error: ResponseError{Code: StatusFileRetry, Message: err.Error()}})
+		return
+	}
+
+	up.Next++
+	up.Received += int64(len(frame.Data))
+	up.LastActivity = time.Now()
+
+	sess.write(Response{ID: req.ID, Result: H{"token": up.Token, "next": up.Next}, Error: ResponseError{Code: StatusFileNext}})
+}
+
+// endChunk 會在客戶端表示所有區塊皆已送出後完成這次上傳，並依序執行該方法原本註冊的處理函式。
+func (e *Engine) endChunk(ctx *Context, sess *Session, req Request, frame fileFrame) {
+	e.uploadsMu.Lock()
+	up, found := e.uploads[frame.Token]
+	ok := found && up.Owner == sess.identity()
+	if ok {
+		delete(e.uploads, frame.Token)
+	}
+	e.uploadsMu.Unlock()
+	if !ok {
+		sess.write(Response{ID: req.ID, Error: ResponseError{Code: StatusFileAbort, Message: "unknown upload token"}})
+		return
+	}
+
+	if err := up.Processor.End(ctx); err != nil {
+		sess.write(Response{ID: req.ID, Error: ResponseError{Code: StatusError, Message: err.Error()}})
+		return
+	}
+
+	ctx.handlers = append(append([]HandlerFunc{}, e.handlers...), up.Method.Handlers...)
+	ctx.index = -1
+
+	e.wg.Add(1)
+	defer e.wg.Done()
+	ctx.Next()
+}
+
+// abortChunk 會中止一次進行中的上傳，並讓處理器清除已寫入的暫存資料。
+func (e *Engine) abortChunk(ctx *Context, sess *Session, req Request, frame fileFrame) {
+	e.uploadsMu.Lock()
+	up, found := e.uploads[frame.Token]
+	ok := found && up.Owner == sess.identity()
+	if ok {
+		delete(e.uploads, frame.Token)
+	}
+	e.uploadsMu.Unlock()
+
+	if ok {
+		up.Processor.Abort(ctx)
+	}
+	sess.write(Response{ID: req.ID, Error: ResponseError{Code: StatusFileAbort}})
+}
+
+// reapExpiredUploads 會中止並清除所有已超過 `defaultUploadTTL` 沒有任何動作的上傳，
+// 避免遺留的上傳權杖與暫存資料（例如 `DefaultChunkProcessor` 開啟的暫存檔案）永遠佔用資源。
+// 此函式會在客戶端斷線時被呼叫，但本身不會依任何特定階段來判斷，因此尚未逾時、
+// 仍可能以同一個 `client_id` 重新連線接續的上傳並不會被這裡中止。
+func (e *Engine) reapExpiredUploads() {
+	e.uploadsMu.Lock()
+	var expired []*uploadSession
+	for token, up := range e.uploads {
+		if up.expired() {
+			expired = append(expired, up)
+			delete(e.uploads, token)
+		}
+	}
+	e.uploadsMu.Unlock()
+
+	for _, up := range expired {
+		ctx := &Context{Keys: map[string]interface{}{"token": up.Token}}
+		up.Processor.Abort(ctx)
+	}
+}
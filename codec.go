@@ -0,0 +1,119 @@
+package mego
+
+import (
+	"bytes"
+	"encoding/gob"
+	"encoding/json"
+	"sync"
+
+	"github.com/vmihailenco/msgpack"
+)
+
+// Codec 是訊息編碼器的介面，引擎會依照每個階段協商後的結果選擇其中一種實作，
+// 將 Request、Response 編碼成位元組或從位元組解碼回來。使用者可透過 `RegisterCodec`
+// 註冊自訂的編碼器（例如 Protobuf、CBOR）而不需修改引擎本身。
+type Codec interface {
+	// Marshal 會將傳入的資料編碼成位元組切片。
+	Marshal(v interface{}) ([]byte, error)
+	// Unmarshal 會將位元組切片解碼至傳入的指標。
+	Unmarshal(data []byte, v interface{}) error
+	// ContentType 回傳此編碼器的名稱。協商時是以 `RegisterCodec` 註冊時的名稱查找編碼器，
+	// 並不會使用此回傳值。
+	ContentType() string
+}
+
+// MsgpackCodec 是以 MessagePack 實作的編碼器，也是引擎預設使用的編碼器。
+type MsgpackCodec struct{}
+
+// Marshal 會將傳入的資料以 MessagePack 編碼成位元組切片。
+func (MsgpackCodec) Marshal(v interface{}) ([]byte, error) {
+	return msgpack.Marshal(v)
+}
+
+// Unmarshal 會將位元組切片以 MessagePack 解碼至傳入的指標。
+func (MsgpackCodec) Unmarshal(data []byte, v interface{}) error {
+	return msgpack.Unmarshal(data, v)
+}
+
+// ContentType 回傳此編碼器的名稱：`msgpack`。
+func (MsgpackCodec) ContentType() string {
+	return "msgpack"
+}
+
+// JSONCodec 是以 JSON 實作的編碼器，讓沒有 MessagePack 實作的瀏覽器端客戶端也能與引擎溝通。
+type JSONCodec struct{}
+
+// Marshal 會將傳入的資料以 JSON 編碼成位元組切片。
+func (JSONCodec) Marshal(v interface{}) ([]byte, error) {
+	return json.Marshal(v)
+}
+
+// Unmarshal 會將位元組切片以 JSON 解碼至傳入的指標。
+func (JSONCodec) Unmarshal(data []byte, v interface{}) error {
+	return json.Unmarshal(data, v)
+}
+
+// ContentType 回傳此編碼器的名稱：`json`。
+func (JSONCodec) ContentType() string {
+	return "json"
+}
+
+// GobCodec 是以 Go 原生的 `encoding/gob` 實作的編碼器，適合單純的 Go 對 Go 連線。
+type GobCodec struct{}
+
+// Marshal 會將傳入的資料以 gob 編碼成位元組切片。
+func (GobCodec) Marshal(v interface{}) ([]byte, error) {
+	var buf bytes.Buffer
+	if err := gob.NewEncoder(&buf).Encode(v); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+// Unmarshal 會將位元組切片以 gob 解碼至傳入的指標。
+func (GobCodec) Unmarshal(data []byte, v interface{}) error {
+	return gob.NewDecoder(bytes.NewReader(data)).Decode(v)
+}
+
+// ContentType 回傳此編碼器的名稱：`gob`。
+func (GobCodec) ContentType() string {
+	return "gob"
+}
+
+// codecsMu 保護 codecs：RegisterCodec 可能隨時被使用者呼叫，而 getCodec 則會在每個新連線
+// 交握時讀取，兩者並非同一個 goroutine，因此需要鎖保護避免並行讀寫造成的崩潰。
+var codecsMu sync.RWMutex
+
+// codecs 保存了所有已註冊的編碼器，並以其名稱作為索引鍵，預設已內建 `msgpack`、`json`、`gob`。
+var codecs = map[string]Codec{
+	"msgpack": MsgpackCodec{},
+	"json":    JSONCodec{},
+	"gob":     GobCodec{},
+}
+
+// RegisterCodec 會以指定的名稱註冊一個編碼器，讓引擎與客戶端能在交握時以此名稱協商選用，
+// 方便使用者擴充如 Protobuf、CBOR 等編碼方式而不需更動引擎本身。
+func RegisterCodec(name string, c Codec) {
+	codecsMu.Lock()
+	defer codecsMu.Unlock()
+	codecs[name] = c
+}
+
+// getCodec 會依照名稱取得已註冊的編碼器，第二個回傳值表示是否存在。
+func getCodec(name string) (Codec, bool) {
+	codecsMu.RLock()
+	defer codecsMu.RUnlock()
+	c, ok := codecs[name]
+	return c, ok
+}
+
+// decodeParams 會將 `Request.Params` 這類已解碼成原生型態（例如 map[string]interface{}）的
+// 參數，透過指定編碼器重新編碼再解碼至 `out` 指標所指向的具體結構，讓 `Params` 不需先固定
+// 成特定型態也能在各個內建方法、區塊上傳流程中還原成各自期望的結構。
+func decodeParams(codec Codec, params interface{}, out interface{}) error {
+	data, err := codec.Marshal(params)
+	if err != nil {
+		return err
+	}
+	return codec.Unmarshal(data, out)
+}
@@ -0,0 +1,50 @@
+package mego
+
+import (
+	"net/http"
+)
+
+// Context 呈現了單次請求在整個處理過程中所共用的上下文資料，會依序傳遞給中介軟體與處理函式。
+type Context struct {
+	// Session 是發出此請求的客戶端階段。
+	Session *Session
+	// Method 是此次請求所呼叫的方法。
+	Method *Method
+	// ID 是此次請求的獨立編號，將會原封不動地夾帶在回應中。
+	ID string
+	// Request 是建立此階段連線時的原始 HTTP 請求。
+	Request *http.Request
+	// Keys 讓處理函式之間可以透過鍵值組傳遞額外資料。
+	Keys map[string]interface{}
+
+	data     interface{}
+	handlers []HandlerFunc
+	index    int
+	status   int
+}
+
+// Next 會接續執行下一個尚未執行的處理函式，通常用於中介軟體在完成前置工作後繼續交棒給下一位。
+func (c *Context) Next() {
+	c.index++
+	for c.index < len(c.handlers) {
+		c.handlers[c.index](c)
+		c.index++
+	}
+}
+
+// Abort 會阻止接下來尚未執行的中介軟體與處理函式被呼叫。
+func (c *Context) Abort() {
+	c.index = len(c.handlers)
+}
+
+// Respond 會將指定的狀態碼與結果資料回應給發出此次請求的客戶端。
+func (c *Context) Respond(status int, result interface{}) {
+	c.status = status
+	c.Session.write(Response{
+		ID:     c.ID,
+		Result: result,
+		Error: ResponseError{
+			Code: status,
+		},
+	})
+}
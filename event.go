@@ -0,0 +1,17 @@
+package mego
+
+// Event 呈現了一個可供客戶端訂閱的事件，其下可以有多個各自獨立的頻道。
+type Event struct {
+	// Name 是此事件的名稱。
+	Name string
+	// Channels 儲存了此事件底下所有的頻道與各自訂閱中的階段。
+	Channels map[string]*Channel
+}
+
+// Channel 呈現了一個事件底下的頻道，訂閱同一個頻道的客戶端會收到相同的廣播內容。
+type Channel struct {
+	// Name 是此頻道的名稱。
+	Name string
+	// Sessions 儲存了訂閱此頻道的所有階段，鍵為階段編號。
+	Sessions map[string]*Session
+}
@@ -0,0 +1,60 @@
+package mego
+
+// Group 呈現了一組擁有共同方法名稱前綴與中介軟體的路由群組，
+// 讓使用者可以如 `e.Group("Chat").Register("Send", ...)` 般將方法組織成 `Chat.Send`。
+type Group struct {
+	// engine 是此群組所屬的引擎。
+	engine *Engine
+	// prefix 是此群組的完整方法名稱前綴。
+	prefix string
+	// handlers 是此群組（包含所有父群組）所累積的中介軟體切片，
+	// 將會被放在此群組底下所註冊方法的處理函式之前。
+	handlers []HandlerFunc
+}
+
+// methodName 會將指定的名稱加上此群組的前綴，組合成完整的方法名稱。
+func (g *Group) methodName(name string) string {
+	if g.prefix == "" {
+		return name
+	}
+	return g.prefix + "." + name
+}
+
+// Use 會將傳入的中介軟體加入此群組，僅有在此之後於此群組（或在此之後才建立的子群組）底下註冊的方法才會套用。
+func (g *Group) Use(handlers ...HandlerFunc) *Group {
+	g.handlers = append(g.handlers, handlers...)
+	return g
+}
+
+// Group 會以此群組的前綴為基礎建立一個巢狀的子群組，子群組會繼承父群組目前已有的中介軟體。
+func (g *Group) Group(prefix string, handlers ...HandlerFunc) *Group {
+	return &Group{
+		engine:   g.engine,
+		prefix:   g.methodName(prefix),
+		handlers: append(append([]HandlerFunc{}, g.handlers...), handlers...),
+	}
+}
+
+// Register 會在此群組底下註冊一個指定的方法，實際註冊的方法名稱會帶有此群組的前綴，
+// 且會自動套用此群組（包含所有父群組）所累積的中介軟體。
+func (g *Group) Register(method string, handler ...HandlerFunc) *Method {
+	handlers := append(append([]HandlerFunc{}, g.handlers...), handler...)
+	return g.engine.registerMethod(g.methodName(method), handlers...)
+}
+
+// Receive 會在此群組底下建立一個指定的方法，並且允許客戶端傳送檔案至此方法。
+func (g *Group) Receive(method string, handler ...HandlerFunc) *Method {
+	return g.ReceiveWith(method, &DefaultChunkProcessor{}, handler...)
+}
+
+// ReceiveWith 會在此群組底下以自訂的區塊處理函式建立指定方法，讓客戶端可上傳檔案至此方法並透過自訂方式進行處理。
+func (g *Group) ReceiveWith(method string, processor ChunkProcessor, handler ...HandlerFunc) *Method {
+	m := g.Register(method, handler...)
+	m.Processor = processor
+	return m
+}
+
+// Event 會在此群組底下建立一個新的事件，實際建立的事件名稱會帶有此群組的前綴。
+func (g *Group) Event(name string) {
+	g.engine.registerEvent(g.methodName(name))
+}
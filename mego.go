@@ -2,10 +2,15 @@ package mego
 
 import (
 	"errors"
+	"fmt"
+	"log"
 	"net/http"
+	"runtime/debug"
+	"sync"
+	"sync/atomic"
+	"time"
 
 	uuid "github.com/satori/go.uuid"
-	"github.com/vmihailenco/msgpack"
 
 	"github.com/olahol/melody"
 )
@@ -70,20 +75,25 @@ type HandlerFunc func(*Context)
 
 // New 會建立一個新的 Mego 空白引擎。
 func New() *Engine {
-	return &Engine{
+	e := &Engine{
 		Sessions: make(map[string]*Session),
 		Events:   make(map[string]*Event),
 		Methods:  make(map[string]*Method),
+		codec:    MsgpackCodec{},
+		uploads:  make(map[string]*uploadSession),
 	}
+	e.root = &Group{engine: e}
+	return e
 }
 
 // Default 會建立一個帶有 `Recovery` 和 `Logger` 中介軟體的 Mego 引擎。
+// `Logger` 必須排在 `Recovery` 之前註冊，讓 `Recovery` 的 `recover` 發生在
+// `Logger` 的 `c.Next()` 呼叫之內，這樣恐慌被吸收後 `Logger` 才能繼續往下
+// 執行並印出這次呼叫的紀錄，而不是讓恐慌直接跳過 `Logger` 未執行的那一段。
 func Default() *Engine {
-	return &Engine{
-		Sessions: make(map[string]*Session),
-		Events:   make(map[string]*Event),
-		Methods:  make(map[string]*Method),
-	}
+	e := New()
+	e.Use(Logger(), Recovery())
+	return e
 }
 
 // server 是基礎伺服器用來與基本 HTTP 連線進行互動。
@@ -110,10 +120,49 @@ type Engine struct {
 	handlers []HandlerFunc
 	// noMethod 是當呼叫不存在方式時所會呼叫的處理函式。
 	noMethod []HandlerFunc
+	// codec 是引擎預設使用的編碼器，新的階段在協商前都會先以此編碼器溝通。
+	codec Codec
+	// root 是沒有前綴的根群組，Register、Event、Receive 等方法皆委派給它處理。
+	root *Group
+	// httpServer 是承載 WebSocket 升級請求的底層 HTTP 伺服器。
+	httpServer *http.Server
+	// wg 用來追蹤所有執行中的處理函式，`Shutdown` 會等待其全數完成才關閉伺服器。
+	wg sync.WaitGroup
+	// closing 表示引擎是否已經開始關閉流程，為 `1` 時將不再接受新的 WebSocket 連線。
+	closing int32
+	// broker 是叢集模式下用來跨節點發布與接收事件的元件，未設置時僅會在本地廣播。
+	broker Broker
+	// registry 是叢集模式下用來登記與查詢節點清單的服務發現元件。
+	registry Registry
+	// subscribeHandler 是 `MegoSubscribe` 的資格檢查函式，預設為 `nil` 表示不限制任何訂閱請求。
+	subscribeHandler func(event string, channel string, c *Context) bool
+	// mu 保護 Sessions、Events、Methods 等會在多個 Goroutine 間併發讀寫的映射表。
+	mu sync.RWMutex
+	// uploads 是進行中的區塊上傳階段，以上傳權杖（token）為鍵，讓客戶端能在斷線重連後
+	// 帶著同一個權杖重新呼叫 Begin 以接續上傳進度。
+	uploads map[string]*uploadSession
+	// uploadsMu 保護 uploads。
+	uploadsMu sync.Mutex
 	//
 	websocket *melody.Melody
 }
 
+// Group 會以指定的前綴建立一個新的路由群組，群組底下註冊的方法名稱都會帶有此前綴，
+// 例如 `e.Group("Chat").Register("Send", ...)` 會註冊出 `Chat.Send`。
+func (e *Engine) Group(prefix string, handlers ...HandlerFunc) *Group {
+	return e.root.Group(prefix, handlers...)
+}
+
+// UseCodec 會將引擎預設使用的編碼器換成指定名稱所對應已註冊的編碼器，
+// 之後才建立連線、且未協商出專屬編碼器的階段都會以此編碼器溝通；已連線的階段在連線時
+// 就已取得編碼器，不受此設置影響。
+func (e *Engine) UseCodec(name string) *Engine {
+	if c, ok := getCodec(name); ok {
+		e.codec = c
+	}
+	return e
+}
+
 // EngineOption 是引擎的選項設置。
 type EngineOption struct {
 	// MaxSize 是這個方法允許接收的最大位元組（Bytes）。
@@ -128,6 +177,18 @@ type EngineOption struct {
 	// CheckInterval 是每隔幾秒進行一次階段是否仍存在的連線檢查，
 	// 此為輕量檢查而非發送回應至客戶端。
 	CheckInterval int
+	// ReadTimeout 對應底層 `http.Server` 的 `ReadTimeout`。
+	ReadTimeout time.Duration
+	// WriteTimeout 對應底層 `http.Server` 的 `WriteTimeout`。
+	WriteTimeout time.Duration
+	// IdleTimeout 對應底層 `http.Server` 的 `IdleTimeout`。
+	IdleTimeout time.Duration
+	// HandshakeTimeout 是 WebSocket 升級握手的逾時時間。
+	HandshakeTimeout time.Duration
+	// PongWait 是等待客戶端回應 Pong 控制訊息的逾時時間。
+	PongWait time.Duration
+	// PingPeriod 是伺服器向客戶端發送 Ping 控制訊息的間隔，通常應小於 `PongWait`。
+	PingPeriod time.Duration
 }
 
 // Method 呈現了一個方法。
@@ -142,6 +203,13 @@ type Method struct {
 	Option *MethodOption
 }
 
+// WithOption 會替此方法套用專屬的選項，覆蓋引擎的預設設定（例如區塊、檔案大小限制），
+// 回傳自身以便在註冊時鏈式呼叫，例如 `e.Receive("Upload", handler).WithOption(&mego.MethodOption{...})`。
+func (m *Method) WithOption(option *MethodOption) *Method {
+	m.Option = option
+	return m
+}
+
 // MethodOption 是一個方法的選項。
 type MethodOption struct {
 	// MaxSize 是這個方法允許接收的最大位元組（Bytes）。此選項會覆蓋引擎設定。
@@ -153,104 +221,214 @@ type MethodOption struct {
 	MaxFileSize int
 }
 
-// Run 會在指定的埠口執行 Mego 引擎。
-func (e *Engine) Run(port ...string) {
-	// 初始化一個 Melody 套件框架並當作 WebSocket 底層用途。
-	m := melody.New()
-	// 以 WebSocket 初始化一個底層伺服器。
-	s := &server{
-		websocket: m,
-	}
-	e.websocket = m
-
-	// 設定預設埠口。
-	p := ":5000"
-	if len(port) > 0 {
-		p = port[0]
-	}
-
-	// 將接收到的所有訊息轉交給控制器。
-	m.HandleMessage(e.messageHandler)
-	//
-	m.HandleConnect(e.connectHandler)
-
-	// 開始在指定埠口監聽 HTTP 請求並交由底層伺服器處理。
-	http.ListenAndServe(p, s)
-}
-
 // connectHandler 處理連接起始的函式。
 func (e *Engine) connectHandler(s *melody.Session) {
+	// 如果引擎已經開始進行關閉流程，就不再接受新的連線。
+	if atomic.LoadInt32(&e.closing) == 1 {
+		s.Close()
+		return
+	}
+
 	// 替此階段建立一個獨立的 UUID。
 	id := uuid.NewV4().String()
 	// 在底層階段存放此階段的編號。
 	s.Set("ID", id)
+
+	// 預設沿用引擎的編碼器，若客戶端在升級時有帶上 `Sec-WebSocket-Protocol`
+	// 子協定表頭（例如 `json`、`gob`），且該名稱已被註冊過，則改用該編碼器，
+	// 讓不支援 MessagePack 的瀏覽器端客戶端也能直接在握手階段協商好編碼方式。
+	codec := e.codec
+	if s.Request != nil {
+		if name := s.Request.Header.Get("Sec-WebSocket-Protocol"); name != "" {
+			if c, ok := getCodec(name); ok {
+				codec = c
+			}
+		}
+	}
+
 	// 將 Mego 階段放入引擎中保存。
+	e.mu.Lock()
 	e.Sessions[id] = &Session{
 		ID:        id,
 		websocket: s,
+		codec:     codec,
 	}
+	e.mu.Unlock()
 }
 
-// messageHandler 處理所有接收到的訊息，並轉接給相對應的方法處理函式。
-func (e *Engine) messageHandler(s *melody.Session, msg []byte) {
-	var req Request
+// disconnectHandler 處理客戶端斷線的函式，會將該階段自 Sessions 移除，
+// 並依照階段自身保存的訂閱索引，以 O(訂閱數) 的複雜度自每個曾經加入的頻道中移除，
+// 而不需要走訪引擎中的所有事件。
+func (e *Engine) disconnectHandler(s *melody.Session) {
+	id, ok := s.Get("ID")
+	if !ok {
+		return
+	}
 
-	// 將接收到的資料映射到本地請求建構體。
-	if err := msgpack.Unmarshal(msg, &req); err != nil {
-		// 如果發生錯誤則建立錯誤回應建構體，並傳送到客戶端。
-		resp, _ := msgpack.Marshal(Response{
-			Error: ResponseError{
-				Code:    StatusInvalid,
-				Message: err.Error(),
-			},
-		})
-		s.WriteBinary(resp)
+	e.mu.Lock()
+	sess, ok := e.Sessions[id.(string)]
+	if !ok {
+		e.mu.Unlock()
 		return
 	}
 
+	for event, channels := range sess.subscriptions {
+		ev, ok := e.Events[event]
+		if !ok {
+			continue
+		}
+		for channel := range channels {
+			if ch, ok := ev.Channels[channel]; ok {
+				delete(ch.Sessions, sess.ID)
+			}
+		}
+	}
+
+	delete(e.Sessions, sess.ID)
+	e.mu.Unlock()
+
+	// 斷線的客戶端可能會帶著同一個 `client_id` 重新連線並接續尚未完成的上傳，
+	// 因此這裡不會立即中止此階段名下的上傳，只會順手清除所有已逾時遺棄的上傳。
+	// Abort 可能呼叫使用者自訂的區塊處理器，因此必須在引擎鎖之外執行，
+	// 避免該處理器回頭呼叫引擎時形成死結。
+	e.reapExpiredUploads()
+}
+
+// megoInitialize 實作了 `MegoInitialize` 內建方法：將客戶端夾帶的資料保存到階段的鍵值組，
+// 並依照 `codec`、`client_id` 兩個保留欄位協商編碼器、記錄跨重新連線仍保持不變的客戶端識別碼。
+func (e *Engine) megoInitialize(sess *Session, req Request) {
+	// Params 本身解碼後就已經是 map[string]interface{}，不需再重新解碼一次。
+	keys, ok := req.Params.(map[string]interface{})
+	if !ok {
+		return
+	}
+
+	// 客戶端可在初始化的第一個訊息中夾帶 `codec` 欄位，
+	// 指定後續訊息欲改用的編碼器名稱（例如尚未以子協定表頭協商時）。
+	if name, ok := keys["codec"].(string); ok {
+		if c, ok := getCodec(name); ok {
+			sess.codec = c
+		}
+		delete(keys, "codec")
+	}
+
+	// 客戶端可夾帶 `client_id` 欄位，作為斷線重連後仍能辨認同一客戶端的識別碼，
+	// 例如用來接續先前尚未完成的區塊上傳。
+	if id, ok := keys["client_id"].(string); ok && id != "" {
+		sess.ClientID = id
+		delete(keys, "client_id")
+	}
+
+	sess.Keys = keys
+}
+
+// safeDispatch 會以 `recover` 包住內建方法（MegoInitialize、MegoSubscribe、MegoUnsubscribe）的執行，
+// 讓這些不會進入一般處理函式鏈、因此不受 `Recovery` 中介軟體保護的內建分派路徑，
+// 也能獲得與一般方法相同的恐慌防護，避免單一請求的錯誤拖垮整個引擎。
+func (e *Engine) safeDispatch(sess *Session, req Request, fn func(*Session, Request)) {
+	defer func() {
+		if r := recover(); r != nil {
+			stack := debug.Stack()
+			if len(stack) > maxStackSize {
+				stack = stack[:maxStackSize]
+			}
+			log.Printf("mego: panic recovered in %q: %v\n%s", req.Method, r, stack)
+			sess.write(Response{ID: req.ID, Error: ResponseError{Code: StatusError, Message: fmt.Sprintf("panic in %q: %v\n%s", req.Method, r, stack)}})
+		}
+	}()
+	fn(sess, req)
+}
+
+// messageHandler 處理所有接收到的訊息，並轉接給相對應的方法處理函式。
+func (e *Engine) messageHandler(s *melody.Session, msg []byte) {
 	// 取得這個 WebSocket 階段對應的 Mego 階段。
 	id, ok := s.Get("ID")
 	if !ok {
 		return
 	}
 	// 透過獨有編號在引擎中找出相對應的階段資料。
+	e.mu.RLock()
 	sess, ok := e.Sessions[id.(string)]
+	e.mu.RUnlock()
 	if !ok {
 		return
 	}
 
-	// 如果這個請求要呼叫的方法是 Mego 的初始化函式。
-	if req.Method == "MegoInitialize" {
-		// 將接收到的資料映射到本地的 map 型態，並保存到階段資料中的鍵值組。
-		var keys map[string]interface{}
-		if err := msgpack.Unmarshal(req.Params, &keys); err == nil {
-			sess.Keys = keys
-		}
-		return
+	// 尚未協商出專屬編碼器的階段會沿用引擎的預設編碼器。
+	codec := sess.codec
+	if codec == nil {
+		codec = e.codec
 	}
 
-	// 如果客戶端離線了就自動移除他所監聽的事件和所有 Sessions
+	var req Request
 
-	//
+	// 將接收到的資料以此階段所使用的編碼器映射到本地請求建構體。
+	if err := codec.Unmarshal(msg, &req); err != nil {
+		// 如果發生錯誤則建立錯誤回應建構體，並傳送到客戶端。
+		resp, _ := codec.Marshal(Response{
+			Error: ResponseError{
+				Code:    StatusInvalid,
+				Message: err.Error(),
+			},
+		})
+		s.WriteBinary(resp)
+		return
+	}
+
+	// Mego 的內建方法都會直接在這裡處理，不會進入一般的方法分派流程，但仍會以
+	// 與 Recovery 中介軟體相同的方式攔截恐慌，避免內建方法的錯誤拖垮整個引擎；
+	// `MegoSubscribe` 會呼叫使用者自訂、可能耗時的 `subscribeHandler`，因此這裡
+	// 也納入 WaitGroup 追蹤，讓 `Shutdown` 不會在它執行期間就關閉伺服器。
 	switch req.Method {
-	//
 	case "MegoInitialize":
-		// 將接收到的資料映射到本地的 map 型態，並保存到階段資料中的鍵值組。
-		var keys map[string]interface{}
-		if err := msgpack.Unmarshal(req.Params, &keys); err == nil {
-			sess.Keys = keys
-		}
+		e.wg.Add(1)
+		defer e.wg.Done()
+		e.safeDispatch(sess, req, e.megoInitialize)
 		return
 
-	//
 	case "MegoSubscribe":
-		//
+		e.wg.Add(1)
+		defer e.wg.Done()
+		e.safeDispatch(sess, req, func(sess *Session, req Request) {
+			e.megoSubscribe(sess, req, s.Request)
+		})
+		return
+
+	case "MegoUnsubscribe":
+		e.wg.Add(1)
+		defer e.wg.Done()
+		e.safeDispatch(sess, req, e.megoUnsubscribe)
+		return
 	}
 
 	// 呼叫該請求欲呼叫的方法。
+	e.mu.RLock()
 	method, ok := e.Methods[req.Method]
+	e.mu.RUnlock()
 	if !ok {
 		// 如果該方法不存在，就呼叫不存在方法處理函式。
+		ctx := &Context{
+			Session:  sess,
+			ID:       req.ID,
+			Request:  s.Request,
+			data:     req.Params,
+			handlers: e.noMethod,
+			index:    -1,
+		}
+		e.wg.Add(1)
+		defer e.wg.Done()
+		ctx.Next()
+		return
+	}
+
+	// 如果該方法是透過 Receive、ReceiveWith 註冊、允許客戶端上傳檔案的方法，
+	// 就交由區塊上傳流程處理，而非一般的方法分派流程；區塊處理器可能進行任意 I/O，
+	// 因此同樣納入 WaitGroup 追蹤，讓 `Shutdown` 會等待進行中的上傳階段處理完畢。
+	if method.Processor != nil {
+		e.wg.Add(1)
+		defer e.wg.Done()
+		e.handleChunk(sess, s.Request, req, method)
+		return
 	}
 
 	// 建立一個上下文建構體。
@@ -261,14 +439,17 @@ func (e *Engine) messageHandler(s *melody.Session, msg []byte) {
 		Request:  s.Request,
 		data:     req.Params,
 		handlers: e.handlers,
+		index:    -1,
 	}
 	// 將該方法的處理函式推入上下文建構體中供依序執行。
 	ctx.handlers = append(ctx.handlers, method.Handlers...)
 
-	// 如果處理函式數量大於零的話就可以開始執行了。
-	if len(ctx.handlers) > 0 {
-		ctx.handlers[0](ctx)
-	}
+	// 以 WaitGroup 追蹤這次呼叫，讓 Shutdown 能夠等待所有進行中的處理函式執行完畢。
+	e.wg.Add(1)
+	defer e.wg.Done()
+
+	// 依序執行全域中介軟體與該方法的處理函式。
+	ctx.Next()
 }
 
 func (e *Engine) HandleRequest() *Engine {
@@ -282,6 +463,7 @@ func (e *Engine) HandleConnect() *Engine {
 // HandleSubscribe 會更改預設的事件訂閱檢查函式，開發者可傳入一個回呼函式並接收客戶端欲訂閱的事件與頻道和相關資料。
 // 回傳一個 `false` 即表示客戶端的資格不符，將不納入訂閱清單中。該客戶端將無法接收到指定的事件。
 func (e *Engine) HandleSubscribe(handler func(event string, channel string, c *Context) bool) *Engine {
+	e.subscribeHandler = handler
 	return e
 }
 
@@ -293,6 +475,8 @@ func (e *Engine) Use(handlers ...HandlerFunc) *Engine {
 
 // Len 會回傳目前有多少個連線數。
 func (e *Engine) Len() int {
+	e.mu.RLock()
+	defer e.mu.RUnlock()
 	return len(e.Sessions)
 }
 
@@ -310,67 +494,126 @@ func (e *Engine) NoMethod(handler ...HandlerFunc) *Engine {
 
 // Event 會建立一個新的事件，如此一來客戶端方能監聽。
 func (e *Engine) Event(name string) {
+	e.root.Event(name)
+}
+
+// registerEvent 是 Event、群組事件註冊的共用底層實作。
+func (e *Engine) registerEvent(name string) {
+	e.mu.Lock()
+	defer e.mu.Unlock()
 	e.Events[name] = &Event{
-		Name: name,
+		Name:     name,
+		Channels: make(map[string]*Channel),
 	}
 }
 
 // Register 會註冊一個指定的方法，並且允許客戶端呼叫此方法觸發指定韓式。
 func (e *Engine) Register(method string, handler ...HandlerFunc) *Method {
+	return e.root.Register(method, handler...)
+}
+
+// registerMethod 是 Register、群組方法註冊的共用底層實作，直接以完整的方法名稱註冊。
+func (e *Engine) registerMethod(name string, handler ...HandlerFunc) *Method {
 	m := &Method{
-		Name:     method,
+		Name:     name,
 		Handlers: handler,
 	}
-	e.Methods[method] = m
+	e.mu.Lock()
+	e.Methods[name] = m
+	e.mu.Unlock()
 	return m
 }
 
-// Emit 會帶有指定資料並廣播指定事件與頻道，當頻道為空字串時則廣播到所有頻道。
+// Emit 會帶有指定資料並廣播指定事件與頻道，當頻道為空字串時則廣播給該事件底下的所有頻道。
 func (e *Engine) Emit(event string, channel string, result interface{}) error {
+	e.mu.RLock()
 	ev, ok := e.Events[event]
+	e.mu.RUnlock()
 	if !ok {
 		return ErrEventNotFound
 	}
-	if ch == ""
+
+	// 叢集模式下只將事件發布給 Broker，本地的轉發交由 Broker 回傳訊息後的 `dispatchBroker`
+	// 處理；多數 Broker（例如 `RedisBroker`）發布與訂閱共用同一個頻道，發布方自己也會收到
+	// 這次發布的訊息，若這裡還額外寫入本地連線，會讓本地客戶端收到同一筆事件兩次。
+	if e.broker != nil {
+		if data, err := e.codec.Marshal(result); err == nil {
+			e.broker.Publish(event, channel, data)
+		}
+		return nil
+	}
+
+	e.mu.RLock()
+	defer e.mu.RUnlock()
+
+	var firstErr error
+	if channel == "" {
+		if len(ev.Channels) == 0 {
+			return ErrChannelNotFound
+		}
+		for _, ch := range ev.Channels {
+			for _, v := range ch.Sessions {
+				if err := v.write(Response{Event: event, Result: result}); err != nil && firstErr == nil {
+					firstErr = err
+				}
+			}
+		}
+		return firstErr
+	}
 
 	ch, ok := ev.Channels[channel]
 	if !ok {
 		return ErrChannelNotFound
 	}
-	var firstErr error
 	for _, v := range ch.Sessions {
-		v.write(Response{
-			Event:  event,
-			Result: result,
-		})
-		//err := v.websocket.WriteBinary()
-		//if firstErr == nil {
-		//	firstErr = err
-		//}
+		if err := v.write(Response{Event: event, Result: result}); err != nil && firstErr == nil {
+			firstErr = err
+		}
 	}
 
 	return firstErr
 }
 
 // EmitMultiple 會將指定事件與資料向指定的客戶端切片進行廣播。
+// 這只會送達本地連線：`sessions` 是無法序列化給其他節點的 Go 物件，
+// 因此設置 Broker 之後此函式仍只會影響本地，不會發佈到叢集的其他節點。
 func (e *Engine) EmitMultiple(event string, result interface{}, sessions []*Session) error {
-	return nil
+	var firstErr error
+	for _, sess := range sessions {
+		if err := sess.write(Response{Event: event, Result: result}); err != nil && firstErr == nil {
+			firstErr = err
+		}
+	}
+	return firstErr
 }
 
 // EmitFilter 會以過濾函式來決定要將帶有指定資料的事件廣播給誰。
 // 如果過濾函式回傳 `true` 則表示該客戶端會接收到該事件。
+// 這只會送達本地連線：`filter` 是無法序列化給其他節點的 Go 函式，
+// 因此設置 Broker 之後此函式仍只會影響本地，不會發佈到叢集的其他節點；
+// 若需要跨節點廣播，請改用 `Emit` 並以事件頻道表達接收者範圍。
 func (e *Engine) EmitFilter(event string, payload interface{}, filter func(*Session) bool) error {
-	return nil
+	e.mu.RLock()
+	defer e.mu.RUnlock()
+
+	var firstErr error
+	for _, sess := range e.Sessions {
+		if !filter(sess) {
+			continue
+		}
+		if err := sess.write(Response{Event: event, Result: payload}); err != nil && firstErr == nil {
+			firstErr = err
+		}
+	}
+	return firstErr
 }
 
 // Receive 會建立一個指定的方法，並且允許客戶端傳送檔案至此方法。
 func (e *Engine) Receive(method string, handler ...HandlerFunc) *Method {
-	return e.ReceiveWith(method, &DefaultChunkProcessor{}, handler...)
+	return e.root.Receive(method, handler...)
 }
 
 // ReceiveWith 會透過自訂的區塊處理函式建立指定方法，讓客戶端可上傳檔案至此方法並透過自訂方式進行處理。
 func (e *Engine) ReceiveWith(method string, processor ChunkProcessor, handler ...HandlerFunc) *Method {
-	m := e.Register(method, handler...)
-	m.Processor = processor
-	return m
+	return e.root.ReceiveWith(method, processor, handler...)
 }
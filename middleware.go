@@ -0,0 +1,92 @@
+package mego
+
+import (
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"runtime/debug"
+	"time"
+)
+
+// maxStackSize 是 Recovery 中介軟體在記錄恐慌堆疊時所保留的最大位元組數。
+const maxStackSize = 4 << 10 // 4KB
+
+// Recovery 會回傳一個中介軟體，會在呼叫後續的處理函式時以 `recover` 攔截恐慌（panic），
+// 避免單一方法發生的錯誤拖垮整個引擎，並將恐慌轉換成夾帶方法名稱與（截斷後）堆疊的 `StatusError` 回應。
+func Recovery() HandlerFunc {
+	return func(c *Context) {
+		defer func() {
+			if r := recover(); r != nil {
+				stack := debug.Stack()
+				if len(stack) > maxStackSize {
+					stack = stack[:maxStackSize]
+				}
+
+				name := ""
+				if c.Method != nil {
+					name = c.Method.Name
+				}
+				log.Printf("mego: panic recovered in %q: %v\n%s", name, r, stack)
+
+				c.Respond(StatusError, fmt.Sprintf("panic in %q: %v\n%s", name, r, stack))
+				c.Abort()
+			}
+		}()
+		c.Next()
+	}
+}
+
+// LoggerConfig 是 Logger 中介軟體的設置。
+type LoggerConfig struct {
+	// Writer 是紀錄要輸出的目的地，預設為 `os.Stdout`。
+	Writer io.Writer
+	// SkipMethods 是不需要被紀錄的方法名稱。`MegoInitialize` 等內建方法不會經過中介軟體，
+	// 本來就不會被紀錄。
+	SkipMethods []string
+}
+
+// Logger 會以預設的設置回傳一個紀錄每次呼叫的中介軟體。
+func Logger() HandlerFunc {
+	return LoggerWithConfig(LoggerConfig{})
+}
+
+// LoggerWithConfig 會依照傳入的設置回傳一個中介軟體，在每次方法呼叫後輸出一行結構化紀錄，
+// 內容包含階段編號、遠端位址、方法名稱、請求編號、回應狀態碼與耗費時間。
+func LoggerWithConfig(conf LoggerConfig) HandlerFunc {
+	w := conf.Writer
+	if w == nil {
+		w = os.Stdout
+	}
+	skip := make(map[string]bool, len(conf.SkipMethods))
+	for _, m := range conf.SkipMethods {
+		skip[m] = true
+	}
+
+	return func(c *Context) {
+		name := ""
+		if c.Method != nil {
+			name = c.Method.Name
+		}
+		if skip[name] {
+			c.Next()
+			return
+		}
+
+		start := time.Now()
+		c.Next()
+		elapsed := time.Since(start)
+
+		remote := ""
+		if c.Request != nil {
+			remote = c.Request.RemoteAddr
+		}
+		sessionID := ""
+		if c.Session != nil {
+			sessionID = c.Session.ID
+		}
+
+		fmt.Fprintf(w, "[mego] session=%s remote=%s method=%s id=%s status=%d elapsed=%s\n",
+			sessionID, remote, name, c.ID, c.status, elapsed)
+	}
+}
@@ -0,0 +1,92 @@
+package mego
+
+import (
+	"io/ioutil"
+	"os"
+	"sync"
+)
+
+// DefaultChunkProcessor 是預設的區塊處理器，會依序將收到的區塊寫入暫存檔案，
+// 並在上傳結束後透過 Context 的鍵值組 `file` 提供最終的暫存檔案路徑。
+// 同一個方法底下的所有上傳會共用同一個 `DefaultChunkProcessor` 實例，
+// 因此內部改以上傳權杖（token）為鍵分別保存每個上傳對應的暫存檔案，以支援並行上傳。
+type DefaultChunkProcessor struct {
+	mu    sync.Mutex
+	files map[string]*os.File
+}
+
+// open 會回傳指定權杖對應的暫存檔案，尚未開啟過的話就建立一個新的。
+func (p *DefaultChunkProcessor) open(token string) (*os.File, error) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	if p.files == nil {
+		p.files = make(map[string]*os.File)
+	}
+	if f, ok := p.files[token]; ok {
+		return f, nil
+	}
+
+	f, err := ioutil.TempFile("", "mego-upload-*")
+	if err != nil {
+		return nil, err
+	}
+	p.files[token] = f
+	return f, nil
+}
+
+// forget 會取出並移除指定權杖所對應的暫存檔案紀錄。
+func (p *DefaultChunkProcessor) forget(token string) *os.File {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	f := p.files[token]
+	delete(p.files, token)
+	return f
+}
+
+// Begin 會為這次上傳建立一個新的暫存檔案；同一個權杖已開啟過暫存檔案時則沿用，不會重新建立。
+func (p *DefaultChunkProcessor) Begin(c *Context, meta FileMeta) error {
+	token, _ := c.Keys["token"].(string)
+	_, err := p.open(token)
+	return err
+}
+
+// Chunk 會將收到的區塊依序寫入暫存檔案。
+func (p *DefaultChunkProcessor) Chunk(c *Context, seq int, data []byte) error {
+	token, _ := c.Keys["token"].(string)
+	f, err := p.open(token)
+	if err != nil {
+		return err
+	}
+	_, err = f.Write(data)
+	return err
+}
+
+// End 會關閉暫存檔案，並將其最終路徑存放於 Context 的鍵值組 `file` 供後續處理函式使用。
+func (p *DefaultChunkProcessor) End(c *Context) error {
+	token, _ := c.Keys["token"].(string)
+	f := p.forget(token)
+	if f == nil {
+		return nil
+	}
+	defer f.Close()
+
+	if c.Keys == nil {
+		c.Keys = make(map[string]interface{})
+	}
+	c.Keys["file"] = f.Name()
+	return nil
+}
+
+// Abort 會關閉並刪除這次上傳尚未完成的暫存檔案。
+func (p *DefaultChunkProcessor) Abort(c *Context) error {
+	token, _ := c.Keys["token"].(string)
+	f := p.forget(token)
+	if f == nil {
+		return nil
+	}
+	name := f.Name()
+	f.Close()
+	return os.Remove(name)
+}
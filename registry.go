@@ -0,0 +1,26 @@
+package mego
+
+// Registry 讓叢集中的節點可以把自己的位址登記到共用的服務發現系統（例如 etcd、Consul），
+// 並查詢目前叢集中還有哪些節點正在運作，跟 Broker 負責的事件傳遞是互相獨立的關注點。
+type Registry interface {
+	// Register 會將節點編號與位址登記至服務發現系統。
+	Register(nodeID, addr string) error
+	// Deregister 會將節點從服務發現系統中移除。
+	Deregister(nodeID string) error
+	// List 會回傳目前登記在服務發現系統中的所有節點，鍵為節點編號、值為位址。
+	List() (map[string]string, error)
+}
+
+// UseRegistry 會讓引擎改用指定的 Registry 作為叢集節點的服務發現實作。
+func (e *Engine) UseRegistry(r Registry) *Engine {
+	e.registry = r
+	return e
+}
+
+// Nodes 會透過目前設置的 Registry 取得叢集中所有節點，尚未設置 Registry 時回傳空結果。
+func (e *Engine) Nodes() (map[string]string, error) {
+	if e.registry == nil {
+		return map[string]string{}, nil
+	}
+	return e.registry.List()
+}
@@ -0,0 +1,13 @@
+package mego
+
+// Request 呈現了客戶端傳送過來的單次請求，其欄位會依照該階段協商後的編碼器解碼而來。
+type Request struct {
+	// ID 是此請求的獨立編號，客戶端可藉此比對伺服器所回應的結果。
+	ID string `msgpack:"id" json:"id"`
+	// Method 是客戶端欲呼叫的方法名稱。
+	Method string `msgpack:"method" json:"method"`
+	// Params 是解碼後仍保持原生型態的參數（例如 map[string]interface{}、[]interface{}），
+	// 若是以 `[]byte` 儲存則在 JSON 編碼器下會被視為二進位資料而轉成 base64 字串，
+	// 因此改以 `interface{}` 保留，需要特定結構時可透過 `decodeParams` 轉換。
+	Params interface{} `msgpack:"params" json:"params"`
+}
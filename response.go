@@ -0,0 +1,21 @@
+package mego
+
+// Response 呈現了伺服器欲傳送給客戶端的回應資料，會在傳送前依照該階段協商後的編碼器編碼。
+type Response struct {
+	// ID 對應了客戶端請求時所夾帶的獨立編號，讓客戶端可以比對對應的回應。
+	ID string `msgpack:"id,omitempty" json:"id,omitempty"`
+	// Event 表示此回應是由事件廣播所觸發，夾帶了被觸發的事件名稱。
+	Event string `msgpack:"event,omitempty" json:"event,omitempty"`
+	// Error 是此次請求若發生錯誤時所夾帶的錯誤資訊。
+	Error ResponseError `msgpack:"error,omitempty" json:"error,omitempty"`
+	// Result 是此次請求所回傳的結果資料。
+	Result interface{} `msgpack:"result,omitempty" json:"result,omitempty"`
+}
+
+// ResponseError 呈現了回應中所夾帶的錯誤資訊。
+type ResponseError struct {
+	// Code 是此錯誤所對應的狀態碼。
+	Code int `msgpack:"code" json:"code"`
+	// Message 是此錯誤的詳細說明文字。
+	Message string `msgpack:"message,omitempty" json:"message,omitempty"`
+}
@@ -0,0 +1,100 @@
+package mego
+
+import (
+	"context"
+	"net"
+	"net/http"
+	"sync/atomic"
+
+	"github.com/olahol/melody"
+)
+
+// Run 會在指定的埠口上以 HTTP 執行 Mego 引擎，直到發生錯誤或 `Shutdown` 被呼叫為止。
+func (e *Engine) Run(port ...string) error {
+	p := ":5000"
+	if len(port) > 0 {
+		p = port[0]
+	}
+	return e.serve(&http.Server{Addr: p})
+}
+
+// RunTLS 會在指定的埠口上以 HTTPS 執行 Mego 引擎，`certFile`、`keyFile` 為憑證與私鑰的檔案路徑。
+func (e *Engine) RunTLS(addr, certFile, keyFile string) error {
+	srv := &http.Server{Addr: addr}
+	e.prepare(srv)
+	return srv.ListenAndServeTLS(certFile, keyFile)
+}
+
+// RunListener 會透過傳入的 `net.Listener` 執行 Mego 引擎，讓使用者可以自行掌控監聽方式，
+// 例如搭配行程管理工具傳入的檔案描述符，或是 Unix socket。
+func (e *Engine) RunListener(l net.Listener) error {
+	srv := &http.Server{}
+	e.prepare(srv)
+	return srv.Serve(l)
+}
+
+// serve 會依照引擎選項備妥底層伺服器後開始監聽並服務請求。
+func (e *Engine) serve(srv *http.Server) error {
+	e.prepare(srv)
+	return srv.ListenAndServe()
+}
+
+// prepare 會依照引擎選項設置底層的 Melody 套件與 HTTP 伺服器，並掛載訊息與連線處理函式。
+func (e *Engine) prepare(srv *http.Server) {
+	m := melody.New()
+
+	if e.Option != nil {
+		if e.Option.HandshakeTimeout > 0 {
+			m.Upgrader.HandshakeTimeout = e.Option.HandshakeTimeout
+		}
+		if e.Option.PongWait > 0 {
+			m.Config.PongWait = e.Option.PongWait
+		}
+		if e.Option.PingPeriod > 0 {
+			m.Config.PingPeriod = e.Option.PingPeriod
+		}
+
+		srv.ReadTimeout = e.Option.ReadTimeout
+		srv.WriteTimeout = e.Option.WriteTimeout
+		srv.IdleTimeout = e.Option.IdleTimeout
+	}
+
+	e.websocket = m
+	e.httpServer = srv
+	srv.Handler = &server{websocket: m}
+
+	m.HandleMessage(e.messageHandler)
+	m.HandleConnect(e.connectHandler)
+	m.HandleDisconnect(e.disconnectHandler)
+}
+
+// Shutdown 會讓引擎不再接受新的 WebSocket 升級請求、廣播一個 `MegoShutdown` 事件通知所有客戶端，
+// 等待所有進行中的處理函式執行完畢（或直到 `ctx` 的期限超時），最後才關閉所有階段與底層伺服器。
+func (e *Engine) Shutdown(ctx context.Context) error {
+	atomic.StoreInt32(&e.closing, 1)
+
+	e.mu.RLock()
+	for _, sess := range e.Sessions {
+		sess.write(Response{Event: "MegoShutdown"})
+	}
+	e.mu.RUnlock()
+
+	done := make(chan struct{})
+	go func() {
+		e.wg.Wait()
+		close(done)
+	}()
+
+	select {
+	case <-done:
+	case <-ctx.Done():
+	}
+
+	if e.websocket != nil {
+		e.websocket.Close()
+	}
+	if e.httpServer != nil {
+		return e.httpServer.Shutdown(ctx)
+	}
+	return nil
+}
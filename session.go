@@ -0,0 +1,68 @@
+package mego
+
+import (
+	"github.com/olahol/melody"
+)
+
+// Session 呈現了一個已連線的客戶端階段。
+type Session struct {
+	// ID 是此階段的獨立編號，每次連線都會重新產生，斷線重連後並不會保持不變。
+	ID string
+	// ClientID 是客戶端透過 `MegoInitialize` 夾帶的 `client_id` 欄位，用來在斷線重連後
+	// 仍能辨認出是同一個客戶端（`ID` 每次連線都會改變，無法用於此用途）。未提供時為空字串。
+	ClientID string
+	// Keys 是透過 `MegoInitialize` 所夾帶的自訂鍵值組。
+	Keys map[string]interface{}
+	// codec 是此階段經過交握後所使用的編碼器，未協商時會使用引擎的預設編碼器。
+	codec Codec
+	// subscriptions 記錄了此階段目前訂閱了哪些事件與頻道（事件名稱 -> 頻道名稱集合），
+	// 讓斷線時能以 O(訂閱數) 的複雜度清除，而不需要走訪引擎中的所有事件。
+	subscriptions map[string]map[string]bool
+	// websocket 是此階段底層的 Melody 連線。
+	websocket *melody.Session
+}
+
+// identity 回傳可跨重新連線辨識此客戶端的識別碼：已透過 `MegoInitialize` 夾帶
+// `client_id` 的話回傳該值，否則退回僅在本次連線有效的 `ID`。
+func (s *Session) identity() string {
+	if s.ClientID != "" {
+		return s.ClientID
+	}
+	return s.ID
+}
+
+// addSubscription 會記錄此階段訂閱了指定的事件與頻道。
+func (s *Session) addSubscription(event, channel string) {
+	if s.subscriptions == nil {
+		s.subscriptions = make(map[string]map[string]bool)
+	}
+	if s.subscriptions[event] == nil {
+		s.subscriptions[event] = make(map[string]bool)
+	}
+	s.subscriptions[event][channel] = true
+}
+
+// removeSubscription 會移除此階段對指定事件與頻道的訂閱紀錄。
+func (s *Session) removeSubscription(event, channel string) {
+	channels, ok := s.subscriptions[event]
+	if !ok {
+		return
+	}
+	delete(channels, channel)
+	if len(channels) == 0 {
+		delete(s.subscriptions, event)
+	}
+}
+
+// write 會將指定的回應以此階段所使用的編碼器編碼後傳送給客戶端。
+func (s *Session) write(resp Response) error {
+	c := s.codec
+	if c == nil {
+		c = MsgpackCodec{}
+	}
+	data, err := c.Marshal(resp)
+	if err != nil {
+		return err
+	}
+	return s.websocket.WriteBinary(data)
+}
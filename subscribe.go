@@ -0,0 +1,96 @@
+package mego
+
+import (
+	"net/http"
+)
+
+// subscribeRequest 是 `MegoSubscribe`、`MegoUnsubscribe` 內建方法所接收的參數格式。
+type subscribeRequest struct {
+	// Event 是客戶端欲訂閱／取消訂閱的事件名稱。
+	Event string `msgpack:"event" json:"event"`
+	// Channel 是客戶端欲訂閱／取消訂閱的頻道名稱。
+	Channel string `msgpack:"channel" json:"channel"`
+	// Data 是客戶端在訂閱時夾帶的額外資料，會交給 `HandleSubscribe` 的資格檢查函式判斷。
+	Data interface{} `msgpack:"data" json:"data"`
+}
+
+// megoSubscribe 實作了 `MegoSubscribe` 內建方法：解析客戶端欲訂閱的事件與頻道，
+// 經過 `HandleSubscribe` 的資格檢查後，將此階段加入 `Event.Channels[channel].Sessions`。
+func (e *Engine) megoSubscribe(sess *Session, req Request, r *http.Request) {
+	codec := sess.codec
+	if codec == nil {
+		codec = e.codec
+	}
+
+	var body subscribeRequest
+	if err := decodeParams(codec, req.Params, &body); err != nil {
+		sess.write(Response{ID: req.ID, Error: ResponseError{Code: StatusInvalid, Message: err.Error()}})
+		return
+	}
+
+	e.mu.RLock()
+	ev, ok := e.Events[body.Event]
+	e.mu.RUnlock()
+	if !ok {
+		sess.write(Response{ID: req.ID, Error: ResponseError{Code: StatusNotFound, Message: ErrEventNotFound.Error()}})
+		return
+	}
+
+	// 資格檢查函式是由使用者提供的回呼，可能會回頭呼叫 Emit 等引擎方法通知其他客戶端，
+	// 因此必須在放開引擎鎖之後才呼叫，避免與這類回呼呼叫形成無法重入的死結。
+	if e.subscribeHandler != nil {
+		ctx := &Context{
+			Session: sess,
+			ID:      req.ID,
+			Request: r,
+			data:    req.Params,
+			Keys:    map[string]interface{}{"data": body.Data},
+		}
+		if !e.subscribeHandler(body.Event, body.Channel, ctx) {
+			sess.write(Response{ID: req.ID, Error: ResponseError{Code: StatusNoPermission}})
+			return
+		}
+	}
+
+	e.mu.Lock()
+	if ev.Channels == nil {
+		ev.Channels = make(map[string]*Channel)
+	}
+	ch, ok := ev.Channels[body.Channel]
+	if !ok {
+		ch = &Channel{Name: body.Channel, Sessions: make(map[string]*Session)}
+		ev.Channels[body.Channel] = ch
+	}
+	ch.Sessions[sess.ID] = sess
+	e.mu.Unlock()
+
+	sess.addSubscription(body.Event, body.Channel)
+
+	sess.write(Response{ID: req.ID, Error: ResponseError{Code: StatusOK}})
+}
+
+// megoUnsubscribe 實作了 `MegoUnsubscribe` 內建方法：將此階段自指定事件的頻道中移除。
+func (e *Engine) megoUnsubscribe(sess *Session, req Request) {
+	codec := sess.codec
+	if codec == nil {
+		codec = e.codec
+	}
+
+	var body subscribeRequest
+	if err := decodeParams(codec, req.Params, &body); err != nil {
+		sess.write(Response{ID: req.ID, Error: ResponseError{Code: StatusInvalid, Message: err.Error()}})
+		return
+	}
+
+	e.mu.Lock()
+	if ev, ok := e.Events[body.Event]; ok {
+		if ch, ok := ev.Channels[body.Channel]; ok {
+			delete(ch.Sessions, sess.ID)
+		}
+	}
+	e.mu.Unlock()
+
+	sess.removeSubscription(body.Event, body.Channel)
+
+	sess.write(Response{ID: req.ID, Error: ResponseError{Code: StatusOK}})
+}